@@ -0,0 +1,441 @@
+// Command ssl-check probes the TLS (or STARTTLS) endpoints of a list of
+// domains and reports certificate and handshake details.
+//
+// A domain is reported OK only when the handshake succeeds AND the chain
+// verifies AND the negotiated version is at least -min-tls AND the
+// certificate has at least -warn-days left; a bare successful handshake is
+// no longer enough. This is a behavior change from earlier versions, which
+// reported OK on a successful handshake alone: scripts parsing this tool's
+// output should check whether that stricter default affects them, or pass
+// -verify=false -min-tls=1.0 -warn-days=0 to recover the old behavior.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/redacid/ssl-checker/pkg/checker"
+)
+
+var (
+	CheckDomainTimeoutMS = flag.Int("timeout", 1000, "Timeout for every one check in milliseconds")
+	Parallel             = flag.Int("parallel", 10, "Parallel check count")
+	HTTPSPort            = flag.String("port", "443", "What port check")
+	IPNetworksFileName   = flag.String("networks", "", "Path to file with allowable ip/networks for connect to check domain. One network/subnet or ip address per line. Can use #-styled comments. Allow all by default.")
+	WarnDays             = flag.Int("warn-days", 30, "Warn (and fail) when the leaf certificate expires in fewer than this many days")
+	Verify               = flag.Bool("verify", true, "Fail domains whose certificate chain does not verify against the system root store")
+	MinTLSVersionFlag    = flag.String("min-tls", "1.2", "Minimum acceptable negotiated TLS version: 1.0, 1.1, 1.2 or 1.3")
+	OutputFormat         = flag.String("output", "text", "Output format: text, json, csv or ndjson")
+	IPMode               = flag.String("ip-mode", "auto", "Address selection: auto (race IPv4/IPv6, RFC 8305 Happy Eyeballs), v4, v6 or both (probe every resolved address)")
+	StartTLSProto        = flag.String("starttls", "", "Negotiate TLS via STARTTLS for a non-HTTPS protocol: smtp, imap, pop3, ftp, ldap, postgres, xmpp or mysql. Empty connects with TLS directly, as for HTTPS.")
+	RPS                  = flag.Float64("rps", 0, "Global limit on dial attempts per second. 0 means unlimited.")
+	PerIPRPS             = flag.Float64("per-ip-rps", 0, "Limit on dial attempts per second against any single resolved IP. 0 means unlimited.")
+	PerIPConcurrency     = flag.Int("per-ip-concurrency", 0, "Limit on in-flight checks against the same /24 (IPv4) or /64 (IPv6) prefix. 0 means unlimited.")
+	ShowStats            = flag.Bool("stats", false, "Print throttling counters (checks started/throttled/failed on timeout) to stderr when done")
+)
+
+// Task pairs a domain with the outcome of checking it.
+type Task struct {
+	Domain string
+	Result checker.CheckResult
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	var allowedNetworks []net.IPNet
+	if *IPNetworksFileName != "" {
+		allowedNetworks = parseNetworksFile(*IPNetworksFileName)
+	}
+
+	minTLSVersion, err := checker.ParseTLSVersion(*MinTLSVersionFlag)
+	if err != nil {
+		log.Fatalf("Invalid -min-tls value %q: %v\n", *MinTLSVersionFlag, err)
+	}
+
+	switch *IPMode {
+	case "auto", "v4", "v6", "both":
+	default:
+		log.Fatalf("Invalid -ip-mode value %q: expected one of auto, v4, v6, both\n", *IPMode)
+	}
+
+	if *StartTLSProto != "" {
+		defaultPort, ok := checker.DefaultPort(*StartTLSProto)
+		if !ok {
+			log.Fatalf("Invalid -starttls value %q: expected one of smtp, imap, pop3, ftp, ldap, postgres, xmpp, mysql\n", *StartTLSProto)
+		}
+		portSet := false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "port" {
+				portSet = true
+			}
+		})
+		if !portSet {
+			*HTTPSPort = defaultPort
+		}
+	}
+
+	c, err := checker.New(checker.Config{
+		Port:             *HTTPSPort,
+		Timeout:          time.Millisecond * time.Duration(*CheckDomainTimeoutMS),
+		IPMode:           *IPMode,
+		AllowedNetworks:  allowedNetworks,
+		Verify:           *Verify,
+		MinTLSVersion:    minTLSVersion,
+		WarnDays:         *WarnDays,
+		StartTLSProto:    *StartTLSProto,
+		RPS:              *RPS,
+		PerIPRPS:         *PerIPRPS,
+		PerIPConcurrency: *PerIPConcurrency,
+	})
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	var domains = make(chan string, *Parallel)
+	var results = make(chan Task, *Parallel)
+
+	// Read domains in background
+	go func() {
+		if flag.NArg() == 0 {
+			stdinReader(domains)
+		} else {
+			filesReader(domains, flag.Args()...)
+		}
+		close(domains)
+	}()
+
+	// Start check domains
+	var wg = sync.WaitGroup{}
+	for i := 0; i < *Parallel; i++ {
+		wg.Add(1) // Out of go func - for sync increment
+		go func() {
+			for domain := range domains {
+				result, _ := c.Check(context.Background(), domain)
+				results <- Task{Domain: domain, Result: result}
+			}
+			wg.Done()
+		}()
+	}
+
+	// Close out channel when all checkers complere work
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Collect results and render them in the requested format
+	var allResults []Task
+	for res := range results {
+		allResults = append(allResults, res)
+	}
+
+	if err := writeResults(os.Stdout, *OutputFormat, allResults); err != nil {
+		log.Fatalf("Error while writing output: %v\n", err)
+	}
+
+	if *ShowStats {
+		stats := c.Stats()
+		log.Printf("checks_started=%v checks_throttled=%v checks_failed_timeout=%v\n",
+			stats.ChecksStarted, stats.ChecksThrottled, stats.ChecksFailedTimeout)
+	}
+}
+
+func usage() {
+	fmt.Printf(`%v [options] [file1 file2 ...]
+file1 file2 ... - files with list of domains to check.
+If no files - use stdinput
+
+List of domains: one or several domains per line. In a line domain separated by comma.
+Example:
+domain.ru
+domain2.ru,www.domain2.ru
+
+options:
+`, os.Args[0])
+
+	flag.PrintDefaults()
+}
+
+func parseNetworksFile(fname string) []net.IPNet {
+	var res = []net.IPNet{}
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatalf("Can't open ip filters file '%v': %v\n", fname, err)
+	}
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		// Cut comment
+		if strings.Index(line, "#") != -1 {
+			line = line[:strings.Index(line, "#")]
+		}
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		if strings.Index(line, "/") != -1 {
+			// CIDR
+			_, ipnet, err := net.ParseCIDR(line)
+			if err != nil {
+				log.Printf("Error while parse filters file '%v' line %v (%v):%v (CIDR)\n", fname, lineNum, line, err)
+				continue
+			}
+			res = append(res, *ipnet)
+		} else {
+			ip := net.ParseIP(line)
+			switch {
+
+			case ip == nil:
+				log.Printf("Error while parse filters file '%v' line %v (%v). (IP)\n", fname, lineNum, line)
+				continue
+			case ip.To4() != nil:
+				res = append(res, net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)})
+			case len(ip) == net.IPv6len:
+				res = append(res, net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)})
+			default:
+				log.Printf("Undetected ip address type: %v\n", line)
+			}
+		}
+	}
+	if scanner.Err() != nil {
+		log.Fatalf("Error while read fileter file '%v': %v\n", fname, scanner.Err())
+	}
+	return res
+}
+
+func filesReader(domains chan<- string, files ...string) {
+	for _, fileName := range files {
+		func() {
+			f, err := os.Open(fileName)
+			if err != nil {
+				log.Printf("Can't open input file '%v': %v\n", fileName, err)
+				return
+			}
+			defer f.Close()
+			log.Printf("Read file '%v'\n", fileName)
+
+			scanner := bufio.NewScanner(f)
+			scanner.Split(splitDomains)
+			for scanner.Scan() {
+				domains <- scanner.Text()
+			}
+			if scanner.Err() != nil {
+				fmt.Printf("Error while read input file '%v': %v\n", fileName, scanner.Err())
+			}
+		}()
+	}
+}
+
+func stdinReader(domains chan<- string) {
+	fmt.Println("Read stdin")
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Split(splitDomains)
+	for scanner.Scan() {
+		domains <- scanner.Text()
+	}
+	if scanner.Err() != nil {
+		log.Printf("Error while read domains: %v\n", scanner.Err())
+	}
+}
+
+func splitDomains(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// copy of bufio.ScanWords, but add comma separator
+
+	// Skip leading spaces and commas
+	start := 0
+	for width := 0; start < len(data); start += width {
+		var r rune
+		r, width = utf8.DecodeRune(data[start:])
+		if !(unicode.IsSpace(r) || r == ',') {
+			break
+		}
+	}
+	// Scan until space or comma, marking end of word.
+	for width, i := 0, start; i < len(data); i += width {
+		var r rune
+		r, width = utf8.DecodeRune(data[i:])
+		if unicode.IsSpace(r) || r == ',' {
+			advance, token, err = i+width, data[start:i], nil
+			return
+		}
+	}
+	// If we're at EOF, we have a final, non-empty, non-terminated word. Return it.
+	if atEOF && len(data) > start {
+		advance, token, err = len(data), data[start:], nil
+		return
+	}
+	// Request more data.
+	advance, token, err = start, nil, nil
+	return
+}
+
+// Record is one flattened (domain, ip) output record, as described by
+// -output=json|csv|ndjson: one record per per-IP result of the check (every
+// resolved address with -ip-mode=both; otherwise the raced connection plus
+// any address denied by -networks), or a single record with only
+// domain/connect_error set on lookup failure.
+type Record struct {
+	Domain         string   `json:"domain"`
+	ResolvedIP     string   `json:"resolved_ip,omitempty"`
+	IPFamily       string   `json:"ip_family,omitempty"`
+	ConnectError   string   `json:"connect_error,omitempty"`
+	StartTLSError  string   `json:"starttls_error,omitempty"`
+	HandshakeError string   `json:"handshake_error,omitempty"`
+	TLSVersion     string   `json:"tls_version,omitempty"`
+	Cipher         string   `json:"cipher,omitempty"`
+	CertSubject    string   `json:"cert_subject,omitempty"`
+	CertIssuer     string   `json:"cert_issuer,omitempty"`
+	SANs           []string `json:"sans,omitempty"`
+	NotBefore      string   `json:"not_before,omitempty"`
+	NotAfter       string   `json:"not_after,omitempty"`
+	DaysLeft       int      `json:"days_left,omitempty"`
+	ChainValid     bool     `json:"chain_valid"`
+}
+
+var csvHeader = []string{
+	"domain", "resolved_ip", "ip_family", "connect_error", "starttls_error", "handshake_error",
+	"tls_version", "cipher", "cert_subject", "cert_issuer", "sans",
+	"not_before", "not_after", "days_left", "chain_valid",
+}
+
+// toRecords flattens a CheckResult into one Record per IPResult. A domain
+// that failed to resolve produces a single record carrying the lookup error.
+func toRecords(result checker.CheckResult) []Record {
+	if result.LookupError != nil {
+		return []Record{{Domain: result.Domain, ConnectError: result.LookupError.Error()}}
+	}
+
+	records := make([]Record, 0, len(result.IPResults))
+	for _, ip := range result.IPResults {
+		rec := Record{
+			Domain:      result.Domain,
+			ResolvedIP:  ip.IP.String(),
+			IPFamily:    ip.IPFamily,
+			CertSubject: ip.Subject,
+			CertIssuer:  ip.Issuer,
+			SANs:        ip.SANs,
+			DaysLeft:    ip.DaysLeft,
+			ChainValid:  ip.ChainValid,
+		}
+		if ip.ConnectError != nil {
+			rec.ConnectError = ip.ConnectError.Error()
+		}
+		if ip.StartTLSError != nil {
+			rec.StartTLSError = ip.StartTLSError.Error()
+		}
+		if ip.HandshakeError != nil {
+			rec.HandshakeError = ip.HandshakeError.Error()
+		}
+		if ip.TLSVersion != 0 {
+			rec.TLSVersion = checker.TLSVersionName(ip.TLSVersion)
+			rec.Cipher = checker.CipherSuiteName(ip.CipherSuite)
+		}
+		if !ip.NotBefore.IsZero() {
+			rec.NotBefore = ip.NotBefore.Format(time.RFC3339)
+		}
+		if !ip.NotAfter.IsZero() {
+			rec.NotAfter = ip.NotAfter.Format(time.RFC3339)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// writeResults renders every task's CheckResult to w in the requested format.
+func writeResults(w io.Writer, format string, tasks []Task) error {
+	switch format {
+	case "text":
+		return writeText(w, tasks)
+	case "json":
+		return writeJSON(w, tasks)
+	case "ndjson":
+		return writeNDJSON(w, tasks)
+	case "csv":
+		return writeCSV(w, tasks)
+	default:
+		return fmt.Errorf("unknown output format %q, expected one of text, json, csv, ndjson", format)
+	}
+}
+
+func writeText(w io.Writer, tasks []Task) error {
+	for _, task := range tasks {
+		result := task.Result
+		if result.LookupError != nil {
+			if _, err := fmt.Fprintf(w, "%v: Error while domain lookup: %v\n", result.Domain, result.LookupError); err != nil {
+				return err
+			}
+			continue
+		}
+		var messages []string
+		for _, ip := range result.IPResults {
+			messages = append(messages, ip.Message)
+		}
+		if _, err := fmt.Fprintf(w, "%v: %v\n", result.Domain, strings.Join(messages, "; ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, tasks []Task) error {
+	var records []Record
+	for _, task := range tasks {
+		records = append(records, toRecords(task.Result)...)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+func writeNDJSON(w io.Writer, tasks []Task) error {
+	enc := json.NewEncoder(w)
+	for _, task := range tasks {
+		for _, rec := range toRecords(task.Result) {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, tasks []Task) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		for _, rec := range toRecords(task.Result) {
+			row := []string{
+				rec.Domain, rec.ResolvedIP, rec.IPFamily, rec.ConnectError, rec.StartTLSError, rec.HandshakeError,
+				rec.TLSVersion, rec.Cipher, rec.CertSubject, rec.CertIssuer, strings.Join(rec.SANs, ";"),
+				rec.NotBefore, rec.NotAfter, strconv.Itoa(rec.DaysLeft), strconv.FormatBool(rec.ChainValid),
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
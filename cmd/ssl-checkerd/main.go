@@ -0,0 +1,262 @@
+// Command ssl-checkerd exposes TLS/STARTTLS certificate checks as Prometheus
+// metrics, in the style of blackbox_exporter: an on-demand /probe endpoint
+// for use with a Prometheus probe job, and an optional /metrics endpoint
+// that re-checks a fixed target list on a timer.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redacid/ssl-checker/pkg/checker"
+)
+
+var (
+	Listen      = flag.String("listen", ":9219", "Address to listen on")
+	Timeout     = flag.Duration("timeout", 5*time.Second, "Timeout for each probe")
+	TargetsFile = flag.String("targets", "", "Path to file of host:port[,module] targets to check on a timer and serve from /metrics. Empty disables scheduled mode; /probe still works.")
+	Interval    = flag.Duration("interval", 5*time.Minute, "How often to re-check targets loaded from -targets")
+	Verify      = flag.Bool("verify", true, "Fail checks whose certificate chain does not verify against the system root store")
+	MinTLSFlag  = flag.String("min-tls", "1.2", "Minimum acceptable negotiated TLS version: 1.0, 1.1, 1.2 or 1.3")
+)
+
+// moduleProtos maps a Prometheus-style probe module name to the STARTTLS
+// protocol it selects. "tcp_tls" dials TLS directly, as for HTTPS.
+var moduleProtos = map[string]string{
+	"tcp_tls":        "",
+	"starttls_smtp":  "smtp",
+	"starttls_imap":  "imap",
+	"starttls_pop3":  "pop3",
+	"starttls_ftp":   "ftp",
+	"starttls_ldap":  "ldap",
+	"starttls_pgsql": "postgres",
+	"starttls_mysql": "mysql",
+	"starttls_xmpp":  "xmpp",
+}
+
+func main() {
+	flag.Parse()
+
+	minTLSVersion, err := checker.ParseTLSVersion(*MinTLSFlag)
+	if err != nil {
+		log.Fatalf("Invalid -min-tls value %q: %v\n", *MinTLSFlag, err)
+	}
+
+	s := &server{
+		timeout:       *Timeout,
+		verify:        *Verify,
+		minTLSVersion: minTLSVersion,
+	}
+
+	if *TargetsFile != "" {
+		targets, err := loadTargets(*TargetsFile)
+		if err != nil {
+			log.Fatalf("Can't load targets file '%v': %v\n", *TargetsFile, err)
+		}
+		s.scheduled = targets
+		go s.runSchedule()
+	}
+
+	http.HandleFunc("/probe", s.handleProbe)
+	http.HandleFunc("/metrics", s.handleMetrics)
+	log.Printf("Listening on %v\n", *Listen)
+	log.Fatal(http.ListenAndServe(*Listen, nil))
+}
+
+// target is one host:port[,module] entry loaded from -targets.
+type target struct {
+	hostPort string
+	module   string
+}
+
+// server holds everything the HTTP handlers need: shared probe config plus
+// the results of the last scheduled sweep over -targets, if any.
+type server struct {
+	timeout       time.Duration
+	verify        bool
+	minTLSVersion uint16
+
+	scheduled []target
+
+	mu      sync.RWMutex
+	results map[target]checker.CheckResult
+}
+
+// handleProbe checks a single target on demand, for use with Prometheus's
+// blackbox-exporter-style relabeling: ?target=host:port&module=tcp_tls.
+func (s *server) handleProbe(w http.ResponseWriter, r *http.Request) {
+	hostPort := r.URL.Query().Get("target")
+	if hostPort == "" {
+		http.Error(w, "target parameter is required", http.StatusBadRequest)
+		return
+	}
+	module := r.URL.Query().Get("module")
+	if module == "" {
+		module = "tcp_tls"
+	}
+	proto, ok := moduleProtos[module]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown module %q", module), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := s.check(ctx, hostPort, proto)
+	duration := time.Since(start)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderHeader(w)
+	renderTarget(w, hostPort, result, duration)
+}
+
+// handleMetrics serves the last scheduled sweep over -targets. It is empty
+// (but still valid exposition output) if -targets was not set.
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	renderHeader(w)
+	for _, t := range s.scheduled {
+		result, ok := s.results[t]
+		if !ok {
+			continue
+		}
+		renderTarget(w, t.hostPort, result, 0)
+	}
+}
+
+// check resolves host:port into a checker.Config and runs a single check.
+func (s *server) check(ctx context.Context, hostPort, proto string) (checker.CheckResult, error) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return checker.CheckResult{}, fmt.Errorf("invalid target %q: %v", hostPort, err)
+	}
+	c, err := checker.New(checker.Config{
+		Port:          port,
+		Timeout:       s.timeout,
+		Verify:        s.verify,
+		MinTLSVersion: s.minTLSVersion,
+		StartTLSProto: proto,
+	})
+	if err != nil {
+		return checker.CheckResult{}, err
+	}
+	return c.Check(ctx, host)
+}
+
+// runSchedule re-checks every target in s.scheduled every -interval, storing
+// results for handleMetrics to serve.
+func (s *server) runSchedule() {
+	s.sweep()
+	ticker := time.NewTicker(*Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.sweep()
+	}
+}
+
+func (s *server) sweep() {
+	results := make(map[target]checker.CheckResult, len(s.scheduled))
+	for _, t := range s.scheduled {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		result, err := s.check(ctx, t.hostPort, moduleProtos[t.module])
+		cancel()
+		if err != nil {
+			log.Printf("Error while checking target '%v': %v\n", t.hostPort, err)
+			continue
+		}
+		results[t] = result
+	}
+	s.mu.Lock()
+	s.results = results
+	s.mu.Unlock()
+}
+
+// loadTargets reads host:port[,module] entries, one per line. Lines may
+// carry #-styled comments and blank lines are skipped, matching the style of
+// ssl-check's -networks file.
+func loadTargets(fname string) ([]target, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var targets []target
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ",", 2)
+		hostPort := strings.TrimSpace(parts[0])
+		module := "tcp_tls"
+		if len(parts) == 2 {
+			module = strings.TrimSpace(parts[1])
+		}
+		if _, ok := moduleProtos[module]; !ok {
+			return nil, fmt.Errorf("unknown module %q for target %q", module, hostPort)
+		}
+		targets = append(targets, target{hostPort: hostPort, module: module})
+	}
+	return targets, nil
+}
+
+// renderHeader writes the HELP/TYPE lines shared by every metric this
+// exporter emits.
+func renderHeader(w http.ResponseWriter) {
+	fmt.Fprintln(w, "# HELP ssl_probe_success Whether the probe of this address succeeded (handshake completed and every policy check passed); a target-only 0 series means no address was probed")
+	fmt.Fprintln(w, "# TYPE ssl_probe_success gauge")
+	fmt.Fprintln(w, "# HELP ssl_probe_duration_seconds Time the probe took")
+	fmt.Fprintln(w, "# TYPE ssl_probe_duration_seconds gauge")
+	fmt.Fprintln(w, "# HELP ssl_cert_not_after Unix timestamp at which the leaf certificate expires")
+	fmt.Fprintln(w, "# TYPE ssl_cert_not_after gauge")
+	fmt.Fprintln(w, "# HELP ssl_tls_version_info Negotiated TLS version, one constant-1 series per address labeled by version")
+	fmt.Fprintln(w, "# TYPE ssl_tls_version_info gauge")
+}
+
+// renderTarget writes one sample set for a single target's CheckResult.
+func renderTarget(w http.ResponseWriter, hostPort string, result checker.CheckResult, duration time.Duration) {
+	host, _, _ := net.SplitHostPort(hostPort)
+	if host == "" {
+		host = hostPort
+	}
+
+	if result.LookupError != nil || len(result.IPResults) == 0 {
+		fmt.Fprintf(w, "ssl_probe_success{target=%q} 0\n", hostPort)
+		return
+	}
+
+	for _, ip := range result.IPResults {
+		labels := fmt.Sprintf("target=%q,instance=%q,ip=%q", hostPort, host, ip.IP.String())
+		success := 0
+		if ip.Success {
+			success = 1
+		}
+		fmt.Fprintf(w, "ssl_probe_success{%v} %v\n", labels, success)
+		if duration > 0 {
+			fmt.Fprintf(w, "ssl_probe_duration_seconds{%v} %v\n", labels, duration.Seconds())
+		}
+		if !ip.NotAfter.IsZero() {
+			fmt.Fprintf(w, "ssl_cert_not_after{%v} %v\n", labels, ip.NotAfter.Unix())
+		}
+		if ip.TLSVersion != 0 {
+			fmt.Fprintf(w, "ssl_tls_version_info{%v,version=%q} 1\n", labels, checker.TLSVersionName(ip.TLSVersion))
+		}
+	}
+}
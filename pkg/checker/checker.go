@@ -0,0 +1,365 @@
+// Package checker implements TLS/STARTTLS reachability and certificate
+// checks against a domain's resolved addresses. It is the library behind the
+// ssl-check CLI and the ssl-checkerd exporter.
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Config controls how a Checker resolves, dials and evaluates a domain.
+type Config struct {
+	// Port is dialed for every resolved address, e.g. "443" or a
+	// protocol's plaintext port when StartTLSProto is set.
+	Port string
+	// Timeout bounds DNS lookup, each dial attempt and the TLS handshake.
+	Timeout time.Duration
+	// IPMode is one of "auto" (Happy Eyeballs race), "v4", "v6" or "both"
+	// (probe every resolved address). Defaults to "auto" if empty.
+	IPMode string
+	// AllowedNetworks restricts which resolved addresses may be dialed.
+	// A nil slice allows every address.
+	AllowedNetworks []net.IPNet
+	// Verify fails a check when the certificate chain does not verify
+	// against the system root store.
+	Verify bool
+	// MinTLSVersion fails a check when the negotiated version is lower,
+	// e.g. tls.VersionTLS12. Zero means no minimum is enforced.
+	MinTLSVersion uint16
+	// WarnDays fails a check when the leaf certificate expires sooner.
+	WarnDays int
+	// StartTLSProto negotiates TLS via STARTTLS for a non-HTTPS protocol:
+	// smtp, imap, pop3, ftp, ldap, postgres, xmpp or mysql. Empty connects
+	// with TLS directly, as for HTTPS.
+	StartTLSProto string
+	// RPS caps the global rate of dial attempts. Zero means unlimited.
+	RPS float64
+	// PerIPRPS caps the rate of dial attempts against any single resolved
+	// IP. Zero means unlimited.
+	PerIPRPS float64
+	// PerIPConcurrency caps the number of in-flight checks against the same
+	// /24 (IPv4) or /64 (IPv6) prefix. Zero means unlimited.
+	PerIPConcurrency int
+}
+
+// IPResult holds the detailed outcome of a check against a single resolved IP.
+type IPResult struct {
+	IP             net.IP
+	IPFamily       string
+	ConnectError   error
+	StartTLSError  error
+	HandshakeError error
+	TLSVersion     uint16
+	CipherSuite    uint16
+	Subject        string
+	Issuer         string
+	SANs           []string
+	NotBefore      time.Time
+	NotAfter       time.Time
+	DaysLeft       int
+	ChainValid     bool
+	ChainError     error
+	// Success reports whether this address passed every configured policy
+	// check (chain verification, minimum TLS version, expiry warning), not
+	// merely that the handshake completed.
+	Success bool
+	Message string
+}
+
+// CheckResult is the structured outcome of checking a single domain: either a
+// domain-level lookup failure, or per-IP results. With IPMode "both" there is
+// one result per resolved address; otherwise there is one result for the
+// raced connection plus one for each address denied by AllowedNetworks.
+type CheckResult struct {
+	Domain      string
+	LookupError error
+	IPResults   []IPResult
+}
+
+// Checker checks domains against a fixed Config.
+type Checker struct {
+	cfg    Config
+	prober Prober
+	gate   *ipGate
+}
+
+// New builds a Checker, validating cfg.StartTLSProto and applying defaults.
+func New(cfg Config) (*Checker, error) {
+	if cfg.IPMode == "" {
+		cfg.IPMode = "auto"
+	}
+	prober, err := newProber(strings.ToLower(cfg.StartTLSProto))
+	if err != nil {
+		return nil, err
+	}
+	return &Checker{cfg: cfg, prober: prober, gate: newIPGate(cfg)}, nil
+}
+
+// Check resolves domain and probes its addresses per the Checker's Config.
+func (c *Checker) Check(ctx context.Context, domain string) (CheckResult, error) {
+	result := CheckResult{Domain: domain}
+
+	lookupCtx := ctx
+	if c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		lookupCtx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, domain)
+	if err != nil {
+		result.LookupError = err
+		return result, nil
+	}
+	if len(addrs) == 0 {
+		result.LookupError = fmt.Errorf("no ip addresses for the domain")
+		return result, nil
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+
+	allowed, denied := c.filterAllowedIPs(ips)
+	result.IPResults = append(result.IPResults, denied...)
+
+	if c.cfg.IPMode == "both" {
+		for _, ip := range allowed {
+			result.IPResults = append(result.IPResults, c.checkIP(ctx, domain, ip))
+		}
+		return result, nil
+	}
+
+	candidates := allowed
+	if c.cfg.IPMode == "v4" || c.cfg.IPMode == "v6" {
+		candidates = filterByFamily(allowed, c.cfg.IPMode)
+	}
+	result.IPResults = append(result.IPResults, c.raceAndProbe(ctx, domain, candidates))
+	return result, nil
+}
+
+// Stream checks every domain read from in, concurrency-free and in order of
+// arrival, closing the returned channel once in is drained or ctx is done.
+func (c *Checker) Stream(ctx context.Context, in <-chan string) <-chan CheckResult {
+	out := make(chan CheckResult)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case domain, ok := <-in:
+				if !ok {
+					return
+				}
+				result, _ := c.Check(ctx, domain)
+				select {
+				case out <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// filterAllowedIPs splits resolved addresses into those permitted by
+// c.cfg.AllowedNetworks and a denial IPResult for each one that is not.
+func (c *Checker) filterAllowedIPs(ips []net.IP) (allowed []net.IP, denied []IPResult) {
+	if c.cfg.AllowedNetworks == nil {
+		return ips, nil
+	}
+	for _, ip := range ips {
+		ipAllowed := false
+		for _, network := range c.cfg.AllowedNetworks {
+			if network.Contains(ip) {
+				ipAllowed = true
+				break
+			}
+		}
+		if ipAllowed {
+			allowed = append(allowed, ip)
+		} else {
+			denied = append(denied, IPResult{IP: ip, IPFamily: ipFamily(ip), Message: "IP address denied by network filters: " + ip.String()})
+		}
+	}
+	return allowed, denied
+}
+
+func filterByFamily(ips []net.IP, mode string) []net.IP {
+	var out []net.IP
+	for _, ip := range ips {
+		isV4 := ip.To4() != nil
+		if (mode == "v4") == isV4 {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// raceAndProbe dials candidates with the Happy Eyeballs racing dialer and
+// runs the TLS probe against whichever address connects first.
+func (c *Checker) raceAndProbe(ctx context.Context, domain string, candidates []net.IP) IPResult {
+	if len(candidates) == 0 {
+		return IPResult{Message: "No ip addresses available for the selected IP mode"}
+	}
+
+	conn, winner, release, err := dialHappyEyeballs(ctx, candidates, c.cfg.Port, c.cfg.Timeout, c.gate)
+	if err != nil {
+		return IPResult{Message: fmt.Sprintf("Error while connect: %v", err)}
+	}
+	defer release()
+	defer conn.Close()
+
+	// The handshake deadline is computed from when the winning connection was
+	// actually established, not from before the race started: staggered
+	// attempts each get their own fresh c.cfg.Timeout for the dial, so an
+	// attempt that wins late in the stagger must still get a full timeout for
+	// the handshake rather than inheriting an already-expired one.
+	deadLine := time.Now().Add(c.cfg.Timeout)
+	return c.probeTLS(domain, winner, conn, deadLine)
+}
+
+// checkIP dials a single resolved address, performs the TLS handshake and
+// gathers certificate/connection details needed for monitoring decisions.
+func (c *Checker) checkIP(ctx context.Context, domain string, ip net.IP) IPResult {
+	release, err := c.gate.acquire(ctx, ip)
+	if err != nil {
+		return IPResult{IP: ip, IPFamily: ipFamily(ip), ConnectError: err, Message: fmt.Sprintf("Error while connect to IP: %v (%v)", ip, err)}
+	}
+	defer release()
+
+	dialer := net.Dialer{Timeout: c.cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), c.cfg.Port))
+	c.gate.recordDialResult(ip, err)
+	if err != nil {
+		return IPResult{IP: ip, IPFamily: ipFamily(ip), ConnectError: err, Message: fmt.Sprintf("Error while connect to IP: %v (%v)", ip, err)}
+	}
+	defer conn.Close()
+
+	// Computed after the dial succeeds, not before: a deadline set before a
+	// slow connect would leave little or no budget for STARTTLS negotiation
+	// and the handshake, the same bug fixed for raceAndProbe above.
+	deadLine := time.Now().Add(c.cfg.Timeout)
+	return c.probeTLS(domain, ip, conn, deadLine)
+}
+
+// probeTLS runs the TLS handshake over an already-connected socket and
+// gathers certificate/connection details needed for monitoring decisions.
+func (c *Checker) probeTLS(domain string, ip net.IP, conn net.Conn, deadLine time.Time) IPResult {
+	result := IPResult{IP: ip, IPFamily: ipFamily(ip)}
+
+	conn.SetDeadline(deadLine)
+	if err := c.prober.Negotiate(conn, domain); err != nil {
+		result.StartTLSError = err
+		result.Message = fmt.Sprintf("Error during STARTTLS negotiation with IP '%v': %v", ip, err)
+		return result
+	}
+
+	// Always complete the handshake even for untrusted chains so that we can
+	// report certificate details; verification is done explicitly below so
+	// that Verify only governs whether an invalid chain fails the check.
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: domain, InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		result.HandshakeError = err
+		result.Message = fmt.Sprintf("Error while handshake to IP '%v': %v", ip, err)
+		return result
+	}
+
+	state := tlsConn.ConnectionState()
+	result.TLSVersion = state.Version
+	result.CipherSuite = state.CipherSuite
+
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		result.Subject = leaf.Subject.String()
+		result.Issuer = leaf.Issuer.String()
+		result.SANs = leaf.DNSNames
+		result.NotBefore = leaf.NotBefore
+		result.NotAfter = leaf.NotAfter
+		result.DaysLeft = int(time.Until(leaf.NotAfter).Hours() / 24)
+
+		opts := x509.VerifyOptions{DNSName: domain, Intermediates: x509.NewCertPool()}
+		for _, cert := range state.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, verr := leaf.Verify(opts); verr != nil {
+			result.ChainError = verr
+		} else {
+			result.ChainValid = true
+		}
+	}
+
+	var problems []string
+	if c.cfg.Verify && !result.ChainValid {
+		problems = append(problems, fmt.Sprintf("chain does not verify: %v", result.ChainError))
+	}
+	if c.cfg.MinTLSVersion != 0 && result.TLSVersion < c.cfg.MinTLSVersion {
+		problems = append(problems, fmt.Sprintf("negotiated TLS version %v below minimum %v", TLSVersionName(result.TLSVersion), TLSVersionName(c.cfg.MinTLSVersion)))
+	}
+	if c.cfg.WarnDays != 0 && result.DaysLeft < c.cfg.WarnDays {
+		problems = append(problems, fmt.Sprintf("expires in %v days", result.DaysLeft))
+	}
+
+	if len(problems) > 0 {
+		result.Message = fmt.Sprintf("FAIL: %v (%v, %v, expires %v, %v)", ip, TLSVersionName(result.TLSVersion), CipherSuiteName(result.CipherSuite), result.NotAfter.Format(time.RFC3339), strings.Join(problems, "; "))
+		return result
+	}
+
+	result.Success = true
+	result.Message = fmt.Sprintf("OK: %v (%v, %v, expires %v, %v days left)", ip, TLSVersionName(result.TLSVersion), CipherSuiteName(result.CipherSuite), result.NotAfter.Format(time.RFC3339), result.DaysLeft)
+	return result
+}
+
+// ParseTLSVersion converts a human-readable TLS version string (e.g. "1.2")
+// into the corresponding crypto/tls version constant.
+func ParseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version %q, expected one of 1.0, 1.1, 1.2, 1.3", s)
+	}
+}
+
+func TLSVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("unknown (0x%04x)", v)
+	}
+}
+
+func CipherSuiteName(id uint16) string {
+	name := tls.CipherSuiteName(id)
+	if name == "" {
+		return fmt.Sprintf("unknown (0x%04x)", id)
+	}
+	return name
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
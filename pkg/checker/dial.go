@@ -0,0 +1,224 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// happyEyeballsDelay is the stagger between racing successive addresses, per
+// RFC 8305 Happy Eyeballs v2.
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// dialHappyEyeballs races TCP connections to candidates (sorted per RFC 6724)
+// with a 250ms staggered start per RFC 8305, returning the first successful
+// connection and cancelling the rest. gate admits and tracks every dial
+// attempt; the caller must call the returned release func once it is done
+// with the winning connection.
+func dialHappyEyeballs(ctx context.Context, candidates []net.IP, port string, timeout time.Duration, gate *ipGate) (net.Conn, net.IP, func(), error) {
+	sorted := sortAddresses(candidates)
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		conn    net.Conn
+		ip      net.IP
+		release func()
+		err     error
+	}
+	attempts := make(chan attempt, len(sorted))
+
+	var wg sync.WaitGroup
+	for i, ip := range sorted {
+		wg.Add(1)
+		go func(ip net.IP, delay time.Duration) {
+			defer wg.Done()
+
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-raceCtx.Done():
+				return
+			case <-timer.C:
+			}
+
+			release, err := gate.acquire(raceCtx, ip)
+			if err != nil {
+				select {
+				case attempts <- attempt{ip: ip, err: err}:
+				case <-raceCtx.Done():
+				}
+				return
+			}
+
+			dialer := net.Dialer{Timeout: timeout}
+			conn, err := dialer.DialContext(raceCtx, "tcp", net.JoinHostPort(ip.String(), port))
+			gate.recordDialResult(ip, err)
+			select {
+			case attempts <- attempt{conn: conn, ip: ip, release: release, err: err}:
+			case <-raceCtx.Done():
+				if conn != nil {
+					conn.Close()
+				}
+				release()
+			}
+		}(ip, time.Duration(i)*happyEyeballsDelay)
+	}
+	go func() {
+		wg.Wait()
+		close(attempts)
+	}()
+
+	var lastErr error
+	for a := range attempts {
+		if a.err != nil {
+			if a.release != nil {
+				a.release()
+			}
+			lastErr = a.err
+			continue
+		}
+		cancel()
+		go func() {
+			for a := range attempts {
+				if a.conn != nil {
+					a.conn.Close()
+				}
+				if a.release != nil {
+					a.release()
+				}
+			}
+		}()
+		return a.conn, a.ip, a.release, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses to dial")
+	}
+	return nil, nil, nil, lastErr
+}
+
+// sortAddresses orders candidates per a practical subset of RFC 6724
+// destination address selection: prefer a matching address scope, prefer
+// higher precedence (native over 6to4/Teredo), then prefer the longest
+// common prefix with the source address the kernel would pick for that
+// destination.
+func sortAddresses(ips []net.IP) []net.IP {
+	type candidate struct {
+		ip           net.IP
+		src          net.IP
+		scopeMatch   bool
+		precedence   int
+		commonPrefix int
+	}
+
+	candidates := make([]candidate, len(ips))
+	for i, ip := range ips {
+		src := sourceAddrFor(ip)
+		c := candidate{ip: ip, src: src, precedence: addrPrecedence(ip)}
+		if src != nil {
+			c.scopeMatch = addrScope(ip) == addrScope(src)
+			c.commonPrefix = commonPrefixLen(ip, src)
+		}
+		candidates[i] = c
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ci, cj := candidates[i], candidates[j]
+		if (ci.src == nil) != (cj.src == nil) {
+			return ci.src != nil
+		}
+		if ci.scopeMatch != cj.scopeMatch {
+			return ci.scopeMatch
+		}
+		if ci.precedence != cj.precedence {
+			return ci.precedence > cj.precedence
+		}
+		return ci.commonPrefix > cj.commonPrefix
+	})
+
+	sortedIPs := make([]net.IP, len(candidates))
+	for i, c := range candidates {
+		sortedIPs[i] = c.ip
+	}
+	return sortedIPs
+}
+
+// sourceAddrFor returns the source address the kernel would use to reach ip,
+// found via the classic UDP "connect" trick (no packets are actually sent).
+func sourceAddrFor(ip net.IP) net.IP {
+	conn, err := net.Dial("udp", net.JoinHostPort(ip.String(), "80"))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	udpAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	return udpAddr.IP
+}
+
+func addrScope(ip net.IP) int {
+	switch {
+	case ip.IsLoopback():
+		return 0
+	case ip.IsLinkLocalUnicast():
+		return 1
+	case ip.IsPrivate():
+		return 2
+	default:
+		return 3
+	}
+}
+
+// addrPrecedence ranks address types so that native IPv4/IPv6 are preferred
+// over IPv6 transition mechanisms, per the intent of the RFC 6724 policy
+// table (6to4 and Teredo are long-deprecated tunnelling fallbacks).
+func addrPrecedence(ip net.IP) int {
+	switch {
+	case ip.To4() != nil:
+		return 35
+	case isTeredo(ip):
+		return 5
+	case is6to4(ip):
+		return 20
+	default:
+		return 40
+	}
+}
+
+func is6to4(ip net.IP) bool {
+	ip16 := ip.To16()
+	return ip16 != nil && ip16[0] == 0x20 && ip16[1] == 0x02
+}
+
+func isTeredo(ip net.IP) bool {
+	ip16 := ip.To16()
+	return ip16 != nil && ip16[0] == 0x20 && ip16[1] == 0x01 && ip16[2] == 0x00 && ip16[3] == 0x00
+}
+
+// commonPrefixLen returns the number of leading bits shared by a and b.
+func commonPrefixLen(a, b net.IP) int {
+	a16, b16 := a.To16(), b.To16()
+	if a16 == nil || b16 == nil {
+		return 0
+	}
+	n := 0
+	for i := 0; i < len(a16); i++ {
+		x := a16[i] ^ b16[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+package checker
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAddrPrecedence(t *testing.T) {
+	cases := []struct {
+		name string
+		ip   net.IP
+		want int
+	}{
+		{"native ipv4", net.ParseIP("93.184.216.34"), 35},
+		{"native ipv6", net.ParseIP("2606:2800:220:1:248:1893:25c8:1946"), 40},
+		{"6to4", net.ParseIP("2002:5d5d:d82a::1"), 20},
+		{"teredo", net.ParseIP("2001:0:4136:e378:8000:63bf:3fff:fdd2"), 5},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := addrPrecedence(c.ip); got != c.want {
+				t.Errorf("addrPrecedence(%v) = %d, want %d", c.ip, got, c.want)
+			}
+		})
+	}
+}
+
+func TestIs6to4(t *testing.T) {
+	cases := []struct {
+		name string
+		ip   net.IP
+		want bool
+	}{
+		{"6to4 prefix", net.ParseIP("2002:c000:204::1"), true},
+		{"native ipv6", net.ParseIP("2001:db8::1"), false},
+		{"teredo looks similar but isn't", net.ParseIP("2001:0:4136:e378:8000:63bf:3fff:fdd2"), false},
+		{"ipv4", net.ParseIP("192.0.2.1"), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := is6to4(c.ip); got != c.want {
+				t.Errorf("is6to4(%v) = %v, want %v", c.ip, got, c.want)
+			}
+		})
+	}
+}
+
+func TestIsTeredo(t *testing.T) {
+	cases := []struct {
+		name string
+		ip   net.IP
+		want bool
+	}{
+		{"teredo prefix", net.ParseIP("2001:0:4136:e378:8000:63bf:3fff:fdd2"), true},
+		{"6to4 looks similar but isn't", net.ParseIP("2002:c000:204::1"), false},
+		{"native ipv6", net.ParseIP("2001:db8::1"), false},
+		{"ipv4", net.ParseIP("192.0.2.1"), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isTeredo(c.ip); got != c.want {
+				t.Errorf("isTeredo(%v) = %v, want %v", c.ip, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCommonPrefixLen(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b net.IP
+		want int
+	}{
+		{"identical v4", net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.1"), 128},
+		{"differ in last octet", net.ParseIP("192.168.1.0"), net.ParseIP("192.168.1.255"), 120},
+		{"differ in first bit", net.ParseIP("0.0.0.0"), net.ParseIP("128.0.0.0"), 96},
+		{"identical v6", net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::1"), 128},
+		{"differ after shared /32", net.ParseIP("2001:db8::"), net.ParseIP("2001:db8:1::"), 47},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := commonPrefixLen(c.a, c.b); got != c.want {
+				t.Errorf("commonPrefixLen(%v, %v) = %d, want %d", c.a, c.b, got, c.want)
+			}
+		})
+	}
+}
+
+func TestAddrScope(t *testing.T) {
+	cases := []struct {
+		name string
+		ip   net.IP
+		want int
+	}{
+		{"loopback", net.ParseIP("127.0.0.1"), 0},
+		{"link-local", net.ParseIP("169.254.1.1"), 1},
+		{"private", net.ParseIP("10.0.0.1"), 2},
+		{"global", net.ParseIP("93.184.216.34"), 3},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := addrScope(c.ip); got != c.want {
+				t.Errorf("addrScope(%v) = %d, want %d", c.ip, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package checker
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds operator-facing counters for tuning -rps, -per-ip-rps and
+// -per-ip-concurrency against a real workload.
+type Stats struct {
+	// ChecksStarted counts every dial attempt submitted to the gate,
+	// including attempts abandoned because ctx ended while they waited.
+	ChecksStarted int64
+	// ChecksThrottled counts admitted attempts that had to wait for a rate
+	// budget, a coalescing slot, or a backoff cooldown before proceeding.
+	ChecksThrottled int64
+	// ChecksFailedTimeout counts dial attempts that failed with a timeout,
+	// the signal that drives adaptive backoff.
+	ChecksFailedTimeout int64
+}
+
+// Stats returns a snapshot of the Checker's counters.
+func (c *Checker) Stats() Stats {
+	return Stats{
+		ChecksStarted:       atomic.LoadInt64(&c.gate.stats.ChecksStarted),
+		ChecksThrottled:     atomic.LoadInt64(&c.gate.stats.ChecksThrottled),
+		ChecksFailedTimeout: atomic.LoadInt64(&c.gate.stats.ChecksFailedTimeout),
+	}
+}
+
+// backoffBaseCooldown is the cooldown applied after a single connect
+// timeout; each further consecutive timeout to the same IP doubles it, up
+// to backoffMaxShift doublings.
+const (
+	backoffBaseCooldown = 1 * time.Second
+	backoffMaxShift     = 6 // caps the cooldown at 64x backoffBaseCooldown
+)
+
+// ipGate admits dial attempts against the global and per-IP rate budgets,
+// coalesces concurrent attempts against the same /24 (v4) or /64 (v6)
+// prefix, and backs off an IP that keeps timing out. A Checker always has a
+// gate; when Config leaves RPS, PerIPRPS and PerIPConcurrency at zero, only
+// the timeout backoff applies: acquire still waits out an IP's cooldown.
+type ipGate struct {
+	cfg Config
+
+	global *tokenBucket
+
+	perIPMu sync.Mutex
+	perIP   map[string]*tokenBucket
+
+	prefixMu sync.Mutex
+	prefix   map[string]chan struct{}
+
+	backoffMu sync.Mutex
+	backoff   map[string]*backoffState
+
+	stats Stats
+}
+
+type backoffState struct {
+	consecutiveTimeouts int
+	cooldownUntil       time.Time
+}
+
+func newIPGate(cfg Config) *ipGate {
+	return &ipGate{
+		cfg:     cfg,
+		global:  newTokenBucket(cfg.RPS),
+		perIP:   make(map[string]*tokenBucket),
+		prefix:  make(map[string]chan struct{}),
+		backoff: make(map[string]*backoffState),
+	}
+}
+
+// acquire blocks until ip may be dialed under every configured budget, then
+// returns a release func the caller must call exactly once, whether or not
+// the dial it guards succeeds.
+func (g *ipGate) acquire(ctx context.Context, ip net.IP) (release func(), err error) {
+	atomic.AddInt64(&g.stats.ChecksStarted, 1)
+	throttled := false
+
+	var sem chan struct{}
+	if g.cfg.PerIPConcurrency > 0 {
+		sem = g.prefixSemaphore(ip)
+		select {
+		case sem <- struct{}{}:
+		default:
+			throttled = true
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	release = func() {
+		if sem != nil {
+			<-sem
+		}
+	}
+
+	if wait := g.cooldownRemaining(ip); wait > 0 {
+		throttled = true
+		if err := sleep(ctx, wait); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	if waited, err := g.global.wait(ctx); err != nil {
+		release()
+		return nil, err
+	} else if waited {
+		throttled = true
+	}
+
+	if g.cfg.PerIPRPS > 0 {
+		if waited, err := g.perIPLimiter(ip).wait(ctx); err != nil {
+			release()
+			return nil, err
+		} else if waited {
+			throttled = true
+		}
+	}
+
+	if throttled {
+		atomic.AddInt64(&g.stats.ChecksThrottled, 1)
+	}
+	return release, nil
+}
+
+// recordDialResult feeds a dial's outcome back into the IP's backoff state:
+// consecutive timeouts extend an exponential cooldown, anything else clears it.
+func (g *ipGate) recordDialResult(ip net.IP, err error) {
+	key := ip.String()
+	g.backoffMu.Lock()
+	defer g.backoffMu.Unlock()
+
+	state := g.backoff[key]
+	if !isTimeout(err) {
+		delete(g.backoff, key)
+		return
+	}
+	atomic.AddInt64(&g.stats.ChecksFailedTimeout, 1)
+	if state == nil {
+		state = &backoffState{}
+		g.backoff[key] = state
+	}
+	shift := state.consecutiveTimeouts
+	if shift > backoffMaxShift {
+		shift = backoffMaxShift
+	}
+	state.consecutiveTimeouts++
+	state.cooldownUntil = time.Now().Add(backoffBaseCooldown << shift)
+}
+
+func (g *ipGate) cooldownRemaining(ip net.IP) time.Duration {
+	g.backoffMu.Lock()
+	defer g.backoffMu.Unlock()
+	state := g.backoff[ip.String()]
+	if state == nil {
+		return 0
+	}
+	if remaining := time.Until(state.cooldownUntil); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+func (g *ipGate) prefixSemaphore(ip net.IP) chan struct{} {
+	key := prefixKey(ip)
+	g.prefixMu.Lock()
+	defer g.prefixMu.Unlock()
+	sem, ok := g.prefix[key]
+	if !ok {
+		sem = make(chan struct{}, g.cfg.PerIPConcurrency)
+		g.prefix[key] = sem
+	}
+	return sem
+}
+
+func (g *ipGate) perIPLimiter(ip net.IP) *tokenBucket {
+	key := ip.String()
+	g.perIPMu.Lock()
+	defer g.perIPMu.Unlock()
+	b, ok := g.perIP[key]
+	if !ok {
+		b = newTokenBucket(g.cfg.PerIPRPS)
+		g.perIP[key] = b
+	}
+	return b
+}
+
+// prefixKey groups an IP into the coalescing unit -per-ip-concurrency
+// applies to: a /24 for IPv4, a /64 for IPv6.
+func prefixKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		mask := net.CIDRMask(24, 32)
+		return v4.Mask(mask).String()
+	}
+	mask := net.CIDRMask(64, 128)
+	return ip.Mask(mask).String()
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tokenBucket is a minimal rate limiter in the spirit of golang.org/x/time/rate,
+// hand-rolled to keep this module dependency-free. A zero or negative rate
+// means unlimited: wait always returns immediately.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rps float64) *tokenBucket {
+	burst := rps
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rps, burst: burst, tokens: burst}
+}
+
+// wait blocks until a token is available, reporting whether it had to wait.
+func (b *tokenBucket) wait(ctx context.Context) (waited bool, err error) {
+	if b.rate <= 0 {
+		return false, nil
+	}
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		if !b.last.IsZero() {
+			elapsed := now.Sub(b.last).Seconds()
+			b.tokens += elapsed * b.rate
+			if b.tokens > b.burst {
+				b.tokens = b.burst
+			}
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return waited, nil
+		}
+		deficit := 1 - b.tokens
+		delay := time.Duration(deficit / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		waited = true
+		if err := sleep(ctx, delay); err != nil {
+			return waited, err
+		}
+	}
+}
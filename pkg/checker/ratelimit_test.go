@@ -0,0 +1,149 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	cases := []struct {
+		name string
+		rps  float64
+	}{
+		{"zero rate", 0},
+		{"negative rate", -1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := newTokenBucket(c.rps)
+			for i := 0; i < 3; i++ {
+				waited, err := b.wait(context.Background())
+				if err != nil {
+					t.Fatalf("wait() error = %v", err)
+				}
+				if waited {
+					t.Errorf("wait() reported waiting for an unlimited bucket")
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketBurstThenThrottles(t *testing.T) {
+	// A high rate keeps the forced sleep short while still exercising the
+	// refill math: burst tokens are consumed immediately, and the token
+	// after that must wait.
+	b := newTokenBucket(1000)
+	for i := 0; i < int(b.burst); i++ {
+		waited, err := b.wait(context.Background())
+		if err != nil {
+			t.Fatalf("wait() error = %v", err)
+		}
+		if waited {
+			t.Errorf("wait() on burst token %d reported waiting", i)
+		}
+	}
+	waited, err := b.wait(context.Background())
+	if err != nil {
+		t.Fatalf("wait() error = %v", err)
+	}
+	if !waited {
+		t.Errorf("wait() after exhausting the burst did not wait")
+	}
+}
+
+func TestTokenBucketRespectsContextCancellation(t *testing.T) {
+	b := newTokenBucket(1)
+	for i := 0; i < int(b.burst); i++ {
+		if _, err := b.wait(context.Background()); err != nil {
+			t.Fatalf("wait() error = %v", err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.wait(ctx); err == nil {
+		t.Errorf("wait() with a cancelled context returned nil error")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTimeout(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"timeout net.Error", timeoutError{}, true},
+		{"plain error", errors.New("connection refused"), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isTimeout(c.err); got != c.want {
+				t.Errorf("isTimeout(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRecordDialResultBackoffDoubles(t *testing.T) {
+	g := newIPGate(Config{})
+	ip := net.ParseIP("192.0.2.1")
+
+	var cooldowns []time.Duration
+	for i := 0; i < 3; i++ {
+		before := time.Now()
+		g.recordDialResult(ip, timeoutError{})
+		cooldowns = append(cooldowns, g.backoff[ip.String()].cooldownUntil.Sub(before))
+	}
+
+	for i, want := range []time.Duration{backoffBaseCooldown, 2 * backoffBaseCooldown, 4 * backoffBaseCooldown} {
+		// Allow a small margin for the time.Now() calls not being instantaneous.
+		if d := cooldowns[i] - want; d < -10*time.Millisecond || d > 10*time.Millisecond {
+			t.Errorf("cooldown %d = %v, want ~%v", i, cooldowns[i], want)
+		}
+	}
+}
+
+func TestRecordDialResultClearsBackoffOnSuccess(t *testing.T) {
+	g := newIPGate(Config{})
+	ip := net.ParseIP("192.0.2.1")
+
+	g.recordDialResult(ip, timeoutError{})
+	if _, ok := g.backoff[ip.String()]; !ok {
+		t.Fatalf("expected backoff state after a timeout")
+	}
+
+	g.recordDialResult(ip, nil)
+	if _, ok := g.backoff[ip.String()]; ok {
+		t.Errorf("expected backoff state to be cleared after a non-timeout result")
+	}
+}
+
+func TestPrefixKey(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b net.IP
+		same bool
+	}{
+		{"same v4 /24", net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.254"), true},
+		{"different v4 /24", net.ParseIP("192.0.2.1"), net.ParseIP("192.0.3.1"), false},
+		{"same v6 /64", net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::ffff"), true},
+		{"different v6 /64", net.ParseIP("2001:db8:0:1::1"), net.ParseIP("2001:db8:0:2::1"), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := prefixKey(c.a) == prefixKey(c.b); got != c.same {
+				t.Errorf("prefixKey(%v) == prefixKey(%v) = %v, want %v", c.a, c.b, got, c.same)
+			}
+		})
+	}
+}
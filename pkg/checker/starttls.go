@@ -0,0 +1,438 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// starttlsDefaultPorts gives the conventional plaintext port each STARTTLS
+// protocol listens on, used whenever the caller hasn't overridden the port.
+var starttlsDefaultPorts = map[string]string{
+	"smtp":     "25",
+	"imap":     "143",
+	"pop3":     "110",
+	"ftp":      "21",
+	"ldap":     "389",
+	"postgres": "5432",
+	"xmpp":     "5222",
+	"mysql":    "3306",
+}
+
+// DefaultPort returns the conventional plaintext port for a STARTTLS
+// protocol name, as accepted by Config.StartTLSProto.
+func DefaultPort(proto string) (string, bool) {
+	port, ok := starttlsDefaultPorts[strings.ToLower(proto)]
+	return port, ok
+}
+
+// Prober performs whatever plaintext exchange a protocol needs to upgrade an
+// established TCP connection to TLS, before the handshake is attempted. It
+// lets new STARTTLS-style protocols be added without touching Checker.
+type Prober interface {
+	Negotiate(conn net.Conn, domain string) error
+}
+
+// directProber is used when no STARTTLS protocol is selected: the TLS
+// handshake starts immediately, as with HTTPS.
+type directProber struct{}
+
+func (directProber) Negotiate(net.Conn, string) error { return nil }
+
+func newProber(proto string) (Prober, error) {
+	switch proto {
+	case "":
+		return directProber{}, nil
+	case "smtp":
+		return smtpProber{}, nil
+	case "imap":
+		return imapProber{}, nil
+	case "pop3":
+		return pop3Prober{}, nil
+	case "ftp":
+		return ftpProber{}, nil
+	case "ldap":
+		return ldapProber{}, nil
+	case "postgres":
+		return postgresProber{}, nil
+	case "xmpp":
+		return xmppProber{}, nil
+	case "mysql":
+		return mysqlProber{}, nil
+	default:
+		return nil, fmt.Errorf("unknown starttls protocol %q: expected one of smtp, imap, pop3, ftp, ldap, postgres, xmpp, mysql", proto)
+	}
+}
+
+type smtpProber struct{}
+
+func (smtpProber) Negotiate(conn net.Conn, domain string) error {
+	if err := expectSMTPReply(conn, "220"); err != nil {
+		return fmt.Errorf("reading banner: %w", err)
+	}
+	if _, err := fmt.Fprintf(conn, "EHLO checker.local\r\n"); err != nil {
+		return err
+	}
+	if err := expectSMTPReply(conn, "250"); err != nil {
+		return fmt.Errorf("EHLO failed: %w", err)
+	}
+	if _, err := fmt.Fprintf(conn, "STARTTLS\r\n"); err != nil {
+		return err
+	}
+	if err := expectSMTPReply(conn, "220"); err != nil {
+		return fmt.Errorf("starttls refused: %w", err)
+	}
+	return nil
+}
+
+// expectSMTPReply reads a (possibly multiline) SMTP reply and errors unless
+// every line starts with code.
+func expectSMTPReply(conn net.Conn, code string) error {
+	for {
+		line, err := readLine(conn)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, code) {
+			return errors.New(line)
+		}
+		if len(line) < 4 || line[3] != '-' {
+			return nil
+		}
+	}
+}
+
+type imapProber struct{}
+
+func (imapProber) Negotiate(conn net.Conn, domain string) error {
+	banner, err := readLine(conn)
+	if err != nil {
+		return fmt.Errorf("reading banner: %w", err)
+	}
+	if !strings.HasPrefix(banner, "* OK") {
+		return fmt.Errorf("unexpected banner: %v", banner)
+	}
+	if _, err := fmt.Fprintf(conn, "a1 STARTTLS\r\n"); err != nil {
+		return err
+	}
+	resp, err := readLine(conn)
+	if err != nil {
+		return fmt.Errorf("reading STARTTLS response: %w", err)
+	}
+	if !strings.HasPrefix(resp, "a1 OK") {
+		return fmt.Errorf("starttls refused: %v", resp)
+	}
+	return nil
+}
+
+type pop3Prober struct{}
+
+func (pop3Prober) Negotiate(conn net.Conn, domain string) error {
+	banner, err := readLine(conn)
+	if err != nil {
+		return fmt.Errorf("reading banner: %w", err)
+	}
+	if !strings.HasPrefix(banner, "+OK") {
+		return fmt.Errorf("unexpected banner: %v", banner)
+	}
+	if _, err := fmt.Fprintf(conn, "STLS\r\n"); err != nil {
+		return err
+	}
+	resp, err := readLine(conn)
+	if err != nil {
+		return fmt.Errorf("reading STLS response: %w", err)
+	}
+	if !strings.HasPrefix(resp, "+OK") {
+		return fmt.Errorf("starttls refused: %v", resp)
+	}
+	return nil
+}
+
+type ftpProber struct{}
+
+func (ftpProber) Negotiate(conn net.Conn, domain string) error {
+	banner, err := readLine(conn)
+	if err != nil {
+		return fmt.Errorf("reading banner: %w", err)
+	}
+	if !strings.HasPrefix(banner, "220") {
+		return fmt.Errorf("unexpected banner: %v", banner)
+	}
+	if _, err := fmt.Fprintf(conn, "AUTH TLS\r\n"); err != nil {
+		return err
+	}
+	resp, err := readLine(conn)
+	if err != nil {
+		return fmt.Errorf("reading AUTH TLS response: %w", err)
+	}
+	if !strings.HasPrefix(resp, "234") {
+		return fmt.Errorf("starttls refused: %v", resp)
+	}
+	return nil
+}
+
+// readLine reads a single CRLF-terminated line straight off conn, byte by
+// byte. A buffered reader would risk swallowing the first bytes of the TLS
+// handshake that follows on the same connection, so negotiation reads avoid
+// bufio entirely.
+func readLine(conn net.Conn) (string, error) {
+	var buf []byte
+	b := make([]byte, 1)
+	for {
+		n, err := conn.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			buf = append(buf, b[0])
+		}
+		if err != nil {
+			return strings.TrimRight(string(buf), "\r"), err
+		}
+	}
+	return strings.TrimRight(string(buf), "\r"), nil
+}
+
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+type ldapProber struct{}
+
+// Negotiate sends the LDAPv3 StartTLS extended operation (RFC 4511 §4.14)
+// and checks the extended response's resultCode, hand-rolling the handful of
+// BER TLVs involved rather than pulling in an ASN.1/LDAP dependency.
+func (ldapProber) Negotiate(conn net.Conn, domain string) error {
+	if _, err := conn.Write(ldapStartTLSRequest()); err != nil {
+		return err
+	}
+	respBytes, err := readBERMessage(conn)
+	if err != nil {
+		return fmt.Errorf("reading StartTLS response: %w", err)
+	}
+	code, err := ldapExtendedResponseCode(respBytes)
+	if err != nil {
+		return fmt.Errorf("parsing StartTLS response: %w", err)
+	}
+	if code != 0 {
+		return fmt.Errorf("starttls refused: LDAP resultCode %d", code)
+	}
+	return nil
+}
+
+func ldapStartTLSRequest() []byte {
+	messageID := berTLV(0x02, []byte{0x01})              // INTEGER messageID = 1
+	requestName := berTLV(0x80, []byte(ldapStartTLSOID)) // [0] requestName
+	extendedRequest := berTLV(0x77, requestName)         // [APPLICATION 23] ExtendedRequest
+	return berTLV(0x30, append(messageID, extendedRequest...))
+}
+
+// ldapExtendedResponseCode walks an LDAPMessage containing an ExtendedResponse
+// ([APPLICATION 24]) far enough to read its leading resultCode ENUMERATED.
+func ldapExtendedResponseCode(msg []byte) (int, error) {
+	_, envelope, _, err := berReadTLV(msg) // LDAPMessage SEQUENCE
+	if err != nil {
+		return 0, err
+	}
+	_, _, rest, err := berReadTLV(envelope) // messageID INTEGER, discarded
+	if err != nil {
+		return 0, err
+	}
+	const extendedResponseTag = 0x78 // [APPLICATION 24], constructed
+	opTag, opContent, _, err := berReadTLV(rest)
+	if err != nil {
+		return 0, err
+	}
+	if opTag != extendedResponseTag {
+		return 0, fmt.Errorf("unexpected LDAP response tag 0x%02x", opTag)
+	}
+	_, codeBytes, _, err := berReadTLV(opContent) // resultCode ENUMERATED
+	if err != nil {
+		return 0, err
+	}
+	code := 0
+	for _, b := range codeBytes {
+		code = code<<8 | int(b)
+	}
+	return code, nil
+}
+
+func berTLV(tag byte, content []byte) []byte {
+	out := append([]byte{tag}, berLength(len(content))...)
+	return append(out, content...)
+}
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	return []byte{0x81, byte(n)}
+}
+
+// berReadTLV parses one BER tag-length-value off the front of data, returning
+// its content and whatever follows it. Only single-byte tags and length forms
+// up to 0x81 are needed for the LDAP messages this tool sends and reads.
+func berReadTLV(data []byte) (tag byte, content []byte, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, fmt.Errorf("truncated BER data")
+	}
+	tag = data[0]
+	headerLen := 2
+	length := int(data[1])
+	if data[1]&0x80 != 0 {
+		numBytes := int(data[1] & 0x7F)
+		if numBytes == 0 || len(data) < 2+numBytes {
+			return 0, nil, nil, fmt.Errorf("unsupported BER length encoding")
+		}
+		length = 0
+		for i := 0; i < numBytes; i++ {
+			length = length<<8 | int(data[2+i])
+		}
+		headerLen = 2 + numBytes
+	}
+	if len(data) < headerLen+length {
+		return 0, nil, nil, fmt.Errorf("truncated BER value")
+	}
+	return tag, data[headerLen : headerLen+length], data[headerLen+length:], nil
+}
+
+// readBERMessage reads exactly one BER TLV off conn: the header tells us the
+// content length, so there is no risk of buffering past it into the TLS
+// handshake that follows.
+func readBERMessage(conn net.Conn) ([]byte, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, err
+	}
+	var extra []byte
+	length := int(head[1])
+	if head[1]&0x80 != 0 {
+		numBytes := int(head[1] & 0x7F)
+		extra = make([]byte, numBytes)
+		if _, err := io.ReadFull(conn, extra); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range extra {
+			length = length<<8 | int(b)
+		}
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(conn, content); err != nil {
+		return nil, err
+	}
+	full := append([]byte{}, head...)
+	full = append(full, extra...)
+	return append(full, content...), nil
+}
+
+type postgresProber struct{}
+
+// Negotiate sends Postgres's SSLRequest startup message and expects a single
+// 'S' byte back (documented in the Postgres frontend/backend protocol docs).
+func (postgresProber) Negotiate(conn net.Conn, domain string) error {
+	sslRequestCode := uint32(80877103)
+	req := []byte{0, 0, 0, 8, byte(sslRequestCode >> 24), byte(sslRequestCode >> 16), byte(sslRequestCode >> 8), byte(sslRequestCode)}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	resp := make([]byte, 1)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("reading SSLRequest response: %w", err)
+	}
+	switch resp[0] {
+	case 'S':
+		return nil
+	case 'N':
+		return fmt.Errorf("starttls refused: server does not support SSL")
+	default:
+		return fmt.Errorf("unexpected SSLRequest response byte 0x%02x", resp[0])
+	}
+}
+
+type mysqlProber struct{}
+
+// Negotiate reads the server's initial handshake packet and replies with an
+// SSLRequest packet (the leading fields of a full HandshakeResponse, with the
+// CLIENT_SSL capability flag set), after which the TLS handshake begins on
+// the same connection per the MySQL client/server protocol.
+func (mysqlProber) Negotiate(conn net.Conn, domain string) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("reading handshake packet: %w", err)
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	seq := header[3]
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return fmt.Errorf("reading handshake payload: %w", err)
+	}
+
+	const clientSSL = 0x00000800
+	const clientProtocol41 = 0x00000200
+	const utf8mb4GeneralCI = 0x2d
+
+	body := make([]byte, 32)
+	capFlags := uint32(clientSSL | clientProtocol41)
+	body[0], body[1], body[2], body[3] = byte(capFlags), byte(capFlags>>8), byte(capFlags>>16), byte(capFlags>>24)
+	body[4], body[5], body[6], body[7] = 0xff, 0xff, 0xff, 0x00 // max packet size
+	body[8] = utf8mb4GeneralCI
+
+	packet := make([]byte, 4+len(body))
+	packet[0], packet[1], packet[2], packet[3] = byte(len(body)), byte(len(body)>>8), byte(len(body)>>16), seq+1
+	copy(packet[4:], body)
+	_, err := conn.Write(packet)
+	return err
+}
+
+type xmppProber struct{}
+
+// Negotiate opens an XMPP stream, waits for the server to offer <starttls>
+// among its stream features, requests it and expects <proceed/> back.
+func (xmppProber) Negotiate(conn net.Conn, domain string) error {
+	open := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", domain)
+	if _, err := io.WriteString(conn, open); err != nil {
+		return err
+	}
+	features, err := readUntil(conn, "</stream:features>")
+	if err != nil {
+		return fmt.Errorf("reading stream features: %w", err)
+	}
+	if !strings.Contains(features, "<starttls") {
+		return fmt.Errorf("starttls refused: server does not offer starttls")
+	}
+	if _, err := io.WriteString(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+	reply, err := readUntil(conn, ">")
+	if err != nil {
+		return fmt.Errorf("reading starttls reply: %w", err)
+	}
+	if !strings.Contains(reply, "<proceed") {
+		return fmt.Errorf("starttls refused: %v", strings.TrimSpace(reply))
+	}
+	return nil
+}
+
+// readUntil reads from conn byte by byte until the accumulated data contains
+// marker, so that no bytes belonging to the TLS handshake that follows are
+// ever buffered past what was asked for.
+func readUntil(conn net.Conn, marker string) (string, error) {
+	var buf []byte
+	b := make([]byte, 1)
+	for {
+		n, err := conn.Read(b)
+		if n > 0 {
+			buf = append(buf, b[0])
+			if strings.Contains(string(buf), marker) {
+				return string(buf), nil
+			}
+		}
+		if err != nil {
+			return string(buf), err
+		}
+		if len(buf) > 8192 {
+			return string(buf), fmt.Errorf("response too large before seeing %q", marker)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package checker
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestBERLength(t *testing.T) {
+	cases := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x01}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x81, 0x80}},
+		{255, []byte{0x81, 0xff}},
+	}
+	for _, c := range cases {
+		if got := berLength(c.n); !bytes.Equal(got, c.want) {
+			t.Errorf("berLength(%d) = %x, want %x", c.n, got, c.want)
+		}
+	}
+}
+
+func TestBERTLVRoundTrip(t *testing.T) {
+	cases := []struct {
+		name    string
+		tag     byte
+		content []byte
+	}{
+		{"empty content", 0x02, []byte{}},
+		{"short content", 0x04, []byte("hello")},
+		{"long content needs 0x81 length", 0x04, bytes.Repeat([]byte{0xaa}, 200)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			encoded := berTLV(c.tag, c.content)
+			tag, content, rest, err := berReadTLV(encoded)
+			if err != nil {
+				t.Fatalf("berReadTLV() error = %v", err)
+			}
+			if tag != c.tag {
+				t.Errorf("tag = 0x%02x, want 0x%02x", tag, c.tag)
+			}
+			if !bytes.Equal(content, c.content) && !(len(content) == 0 && len(c.content) == 0) {
+				t.Errorf("content = %x, want %x", content, c.content)
+			}
+			if len(rest) != 0 {
+				t.Errorf("rest = %x, want empty", rest)
+			}
+		})
+	}
+}
+
+func TestBERReadTLVLeavesTrailingBytes(t *testing.T) {
+	first := berTLV(0x02, []byte{0x01})
+	second := berTLV(0x04, []byte("x"))
+	tag, content, rest, err := berReadTLV(append(append([]byte{}, first...), second...))
+	if err != nil {
+		t.Fatalf("berReadTLV() error = %v", err)
+	}
+	if tag != 0x02 || !bytes.Equal(content, []byte{0x01}) {
+		t.Fatalf("unexpected first TLV: tag=0x%02x content=%x", tag, content)
+	}
+	if !bytes.Equal(rest, second) {
+		t.Errorf("rest = %x, want %x", rest, second)
+	}
+}
+
+func TestBERReadTLVTruncated(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"header only", []byte{0x02}},
+		{"value shorter than declared length", []byte{0x04, 0x05, 'a', 'b'}},
+		{"long-form length missing bytes", []byte{0x04, 0x81}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, _, _, err := berReadTLV(c.data); err == nil {
+				t.Errorf("berReadTLV(%x) succeeded, want error", c.data)
+			}
+		})
+	}
+}
+
+func TestLdapStartTLSRequestEncodesOID(t *testing.T) {
+	req := ldapStartTLSRequest()
+	tag, envelope, rest, err := berReadTLV(req)
+	if err != nil {
+		t.Fatalf("berReadTLV(request) error = %v", err)
+	}
+	if tag != 0x30 {
+		t.Fatalf("outer tag = 0x%02x, want 0x30 (SEQUENCE)", tag)
+	}
+	if len(rest) != 0 {
+		t.Fatalf("trailing bytes after outer SEQUENCE: %x", rest)
+	}
+
+	_, _, envelopeRest, err := berReadTLV(envelope) // messageID INTEGER
+	if err != nil {
+		t.Fatalf("berReadTLV(messageID) error = %v", err)
+	}
+	opTag, opContent, _, err := berReadTLV(envelopeRest)
+	if err != nil {
+		t.Fatalf("berReadTLV(extendedRequest) error = %v", err)
+	}
+	if opTag != 0x77 {
+		t.Fatalf("op tag = 0x%02x, want 0x77 ([APPLICATION 23])", opTag)
+	}
+	_, oidBytes, _, err := berReadTLV(opContent)
+	if err != nil {
+		t.Fatalf("berReadTLV(requestName) error = %v", err)
+	}
+	if string(oidBytes) != ldapStartTLSOID {
+		t.Errorf("requestName = %q, want %q", oidBytes, ldapStartTLSOID)
+	}
+}
+
+func TestLdapExtendedResponseCode(t *testing.T) {
+	buildResponse := func(code byte) []byte {
+		messageID := berTLV(0x02, []byte{0x01})
+		resultCode := berTLV(0x0a, []byte{code})
+		extendedResponse := berTLV(0x78, resultCode)
+		return berTLV(0x30, append(messageID, extendedResponse...))
+	}
+
+	code, err := ldapExtendedResponseCode(buildResponse(0))
+	if err != nil {
+		t.Fatalf("ldapExtendedResponseCode(success) error = %v", err)
+	}
+	if code != 0 {
+		t.Errorf("code = %d, want 0", code)
+	}
+
+	code, err = ldapExtendedResponseCode(buildResponse(49))
+	if err != nil {
+		t.Fatalf("ldapExtendedResponseCode(failure) error = %v", err)
+	}
+	if code != 49 {
+		t.Errorf("code = %d, want 49", code)
+	}
+}
+
+func TestLdapExtendedResponseCodeWrongTag(t *testing.T) {
+	messageID := berTLV(0x02, []byte{0x01})
+	wrongOp := berTLV(0x79, []byte{0x0a, 0x01, 0x00}) // not [APPLICATION 24]
+	msg := berTLV(0x30, append(messageID, wrongOp...))
+	if _, err := ldapExtendedResponseCode(msg); err == nil {
+		t.Error("ldapExtendedResponseCode() with the wrong response tag succeeded, want error")
+	}
+}
+
+// pipeConn returns a connected pair of net.Conn suitable for feeding bytes to
+// the negotiation readers without any real networking.
+func pipeConn(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	client, server = net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestExpectSMTPReply(t *testing.T) {
+	cases := []struct {
+		name    string
+		code    string
+		reply   string
+		wantErr bool
+	}{
+		{"single line match", "220", "220 mail.example.com ESMTP\r\n", false},
+		{"multiline match", "250", "250-mail.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n", false},
+		{"code mismatch", "220", "421 service not available\r\n", true},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			client, server := pipeConn(t)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				server.Write([]byte(c.reply))
+			}()
+			err := expectSMTPReply(client, c.code)
+			<-done
+			if (err != nil) != c.wantErr {
+				t.Errorf("expectSMTPReply() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestIMAPProberNegotiate(t *testing.T) {
+	cases := []struct {
+		name    string
+		banner  string
+		resp    string
+		wantErr bool
+	}{
+		{"accepted", "* OK IMAP4rev1 ready\r\n", "a1 OK Begin TLS negotiation\r\n", false},
+		{"bad banner", "* BAD not an IMAP server\r\n", "", true},
+		{"refused", "* OK IMAP4rev1 ready\r\n", "a1 NO command disabled\r\n", true},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			client, server := pipeConn(t)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				server.Write([]byte(c.banner))
+				if c.banner == "* OK IMAP4rev1 ready\r\n" {
+					buf := make([]byte, 64)
+					server.Read(buf)
+					server.Write([]byte(c.resp))
+				}
+			}()
+			err := (imapProber{}).Negotiate(client, "mail.example.com")
+			<-done
+			if (err != nil) != c.wantErr {
+				t.Errorf("Negotiate() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestPOP3ProberNegotiate(t *testing.T) {
+	cases := []struct {
+		name    string
+		banner  string
+		resp    string
+		wantErr bool
+	}{
+		{"accepted", "+OK POP3 ready\r\n", "+OK begin TLS\r\n", false},
+		{"bad banner", "-ERR not a POP3 server\r\n", "", true},
+		{"refused", "+OK POP3 ready\r\n", "-ERR unsupported\r\n", true},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			client, server := pipeConn(t)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				server.Write([]byte(c.banner))
+				if c.banner == "+OK POP3 ready\r\n" {
+					buf := make([]byte, 64)
+					server.Read(buf)
+					server.Write([]byte(c.resp))
+				}
+			}()
+			err := (pop3Prober{}).Negotiate(client, "mail.example.com")
+			<-done
+			if (err != nil) != c.wantErr {
+				t.Errorf("Negotiate() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestFTPProberNegotiate(t *testing.T) {
+	cases := []struct {
+		name    string
+		banner  string
+		resp    string
+		wantErr bool
+	}{
+		{"accepted", "220 FTP ready\r\n", "234 AUTH TLS OK\r\n", false},
+		{"bad banner", "421 service not available\r\n", "", true},
+		{"refused", "220 FTP ready\r\n", "502 command not implemented\r\n", true},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			client, server := pipeConn(t)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				server.Write([]byte(c.banner))
+				if c.banner == "220 FTP ready\r\n" {
+					buf := make([]byte, 64)
+					server.Read(buf)
+					server.Write([]byte(c.resp))
+				}
+			}()
+			err := (ftpProber{}).Negotiate(client, "ftp.example.com")
+			<-done
+			if (err != nil) != c.wantErr {
+				t.Errorf("Negotiate() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	client, server := pipeConn(t)
+	go server.Write([]byte("hello world\r\n"))
+	line, err := readLine(client)
+	if err != nil {
+		t.Fatalf("readLine() error = %v", err)
+	}
+	if line != "hello world" {
+		t.Errorf("readLine() = %q, want %q", line, "hello world")
+	}
+}